@@ -4,7 +4,10 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
@@ -14,6 +17,56 @@ type ServerConfig struct {
 	Listen     string           `yaml:"listen"`
 	ServerName string           `yaml:"server_name"`
 	Locations  []LocationConfig `yaml:"locations"`
+
+	// DrainTimeout bounds how long a reload waits for in-flight requests to
+	// finish on this listener before it's force-closed, when the listener is
+	// removed entirely by a reload. Defaults to 30s when unset.
+	DrainTimeout time.Duration `yaml:"drain_timeout"`
+
+	// TLS configures static certificate HTTPS termination. Mutually
+	// exclusive with ACME.
+	TLS TLSConfig `yaml:"tls"`
+	// ACME configures automatic certificates via an ACME CA (e.g. Let's
+	// Encrypt). Mutually exclusive with TLS.
+	ACME ACMEConfig `yaml:"acme"`
+
+	// HTTPRedirect spawns a companion plaintext listener on port 80 that
+	// redirects to this (HTTPS) server block.
+	HTTPRedirect bool `yaml:"http_redirect"`
+	// DisableHTTP2 turns off HTTP/2 negotiation on an otherwise-TLS listener.
+	// HTTP/2 is on by default whenever TLS or ACME is configured.
+	DisableHTTP2 bool `yaml:"disable_http2"`
+	// H2C enables HTTP/2 over cleartext for a plaintext (non-TLS) listener.
+	H2C bool `yaml:"h2c"`
+}
+
+// TLSConfig configures static certificate TLS termination for a server block.
+type TLSConfig struct {
+	CertFile   string   `yaml:"cert_file"`
+	KeyFile    string   `yaml:"key_file"`
+	MinVersion string   `yaml:"min_version"` // "1.0".."1.3", defaults to "1.2"
+	Ciphers    []string `yaml:"ciphers"`
+	ClientCA   string   `yaml:"client_ca"`
+	ClientAuth string   `yaml:"client_auth"` // none, request, require, verify_if_given, require_and_verify
+}
+
+// Enabled reports whether this block has a static cert/key configured.
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != ""
+}
+
+// ACMEConfig configures automatic certificate issuance via ACME
+// (golang.org/x/crypto/acme/autocert).
+type ACMEConfig struct {
+	Email    string   `yaml:"email"`
+	Domains  []string `yaml:"domains"`
+	CacheDir string   `yaml:"cache_dir"`
+	Staging  bool     `yaml:"staging"`
+}
+
+// Enabled reports whether ACME is configured for this block.
+func (a ACMEConfig) Enabled() bool {
+	return a.CacheDir != ""
 }
 
 // LocationConfig represents a location block configuration
@@ -24,18 +77,254 @@ type LocationConfig struct {
 	Index            string            `yaml:"index"`
 	ProxySet         map[string]string `yaml:"proxy_set"`
 	ProxyPassHeaders []string          `yaml:"proxy_pass_headers"`
+
+	// FastCGIPass points at a FastCGI application (e.g. PHP-FPM), either as
+	// "host:port" or "unix:/path/to.sock". Mutually exclusive with ProxyPass
+	// and Root.
+	FastCGIPass   string            `yaml:"fastcgi_pass"`
+	FastCGIIndex  string            `yaml:"fastcgi_index"`
+	FastCGIParams map[string]string `yaml:"fastcgi_param"`
+
+	// Middleware is an ordered chain wrapped around this location's terminal
+	// handler (proxy_pass/root/fastcgi_pass), outermost entry first.
+	Middleware []MiddlewareConfig `yaml:"middleware"`
+
+	// WebSocket configures proxy_pass behavior for Upgrade: websocket
+	// requests. Ignored by locations that don't proxy.
+	WebSocket WebSocketConfig `yaml:"websocket"`
+
+	// Rewrite rules run, in order, before the terminal handler. See
+	// RewriteRule for flag semantics.
+	Rewrite []RewriteRule `yaml:"rewrite"`
+	// Return, when set, ends the request immediately with a fixed
+	// response instead of running the terminal handler.
+	Return *ReturnRule `yaml:"return"`
+	// AddHeader sets response headers on every response this location
+	// sends, after the terminal handler runs.
+	AddHeader map[string]string `yaml:"add_header"`
+	// HideHeader strips response headers the terminal handler set before
+	// they reach the client.
+	HideHeader []string `yaml:"hide_header"`
+	// TryFiles probes candidate paths (relative to Root, with "$uri"
+	// substituted for the request path) in order and serves the first
+	// that exists as a file, falling back to the location's normal
+	// handler if none match. Only meaningful alongside Root.
+	TryFiles []string `yaml:"try_files"`
+}
+
+// RewriteRule rewrites the request path using a regular expression before
+// the terminal handler runs, nginx `rewrite` directive style.
+type RewriteRule struct {
+	Pattern     string `yaml:"pattern"`
+	Replacement string `yaml:"replacement"`
+	// Flag controls what happens after a match: "last" (the default)
+	// rewrites the path and goes on applying the following rules to the
+	// rewritten path; "break" rewrites the path and stops evaluating
+	// further rules. Both then continue to this location's handler.
+	// "redirect" sends the client a 302 to the rewritten path instead.
+	Flag string `yaml:"flag"`
+}
+
+// ReturnRule immediately ends request processing with a fixed status and
+// optional body or Location header (for redirects).
+type ReturnRule struct {
+	Status   int    `yaml:"status"`
+	Body     string `yaml:"body"`
+	Location string `yaml:"location"`
+}
+
+// WebSocketConfig tunes how a proxy_pass location handles hijacked
+// WebSocket connections. All fields are optional; zero timeouts disable the
+// corresponding deadline rather than falling back to a default, since
+// WebSocket connections are long-lived by nature. A zero MaxMessageSize
+// falls back to its default.
+type WebSocketConfig struct {
+	// ReadTimeout/WriteTimeout, when set, are applied as rolling deadlines
+	// on each read/write of the hijacked connection. Unset means no
+	// deadline, i.e. the normal HTTP server idle timeout does not apply to
+	// a hijacked connection.
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+
+	// MaxMessageSize bounds the buffer used to relay each frame between
+	// client and upstream. Defaults to 32KiB when unset.
+	MaxMessageSize int `yaml:"max_message_size"`
+}
+
+// MiddlewareConfig configures one entry in a location's middleware chain.
+// Type selects which middleware it builds; the remaining fields are read
+// only by the middlewares that use them.
+type MiddlewareConfig struct {
+	Type string `yaml:"type"`
+
+	// gzip
+	MinSize   int      `yaml:"min_size"`
+	MIMETypes []string `yaml:"mime_types"`
+
+	// rate_limit
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+
+	// basic_auth
+	HtpasswdFile string `yaml:"htpasswd_file"`
+
+	// cors
+	AllowedOrigins []string `yaml:"allowed_origins"`
+	AllowedMethods []string `yaml:"allowed_methods"`
+	AllowedHeaders []string `yaml:"allowed_headers"`
+}
+
+// UpstreamServer represents a single backend within an upstream group.
+type UpstreamServer struct {
+	URL         string
+	Weight      int
+	MaxFails    int
+	FailTimeout time.Duration
+}
+
+// HealthCheckConfig configures active health probing for an upstream: a
+// dedicated goroutine requests Path from every backend on Interval and
+// flips the backend healthy/unhealthy after HealthyThreshold consecutive
+// successes or UnhealthyThreshold consecutive failures.
+type HealthCheckConfig struct {
+	Path               string        `yaml:"path"`
+	Interval           time.Duration `yaml:"interval"`
+	Timeout            time.Duration `yaml:"timeout"`
+	HealthyThreshold   int           `yaml:"healthy_threshold"`
+	UnhealthyThreshold int           `yaml:"unhealthy_threshold"`
+	ExpectedStatusMin  int           `yaml:"expected_status_min"`
+	ExpectedStatusMax  int           `yaml:"expected_status_max"`
+}
+
+// WithDefaults fills in the zero-value fields of a HealthCheckConfig with
+// sane defaults so upstreams can omit the whole health_check block.
+func (h HealthCheckConfig) WithDefaults() HealthCheckConfig {
+	if h.Path == "" {
+		h.Path = "/"
+	}
+	if h.Interval <= 0 {
+		h.Interval = 10 * time.Second
+	}
+	if h.Timeout <= 0 {
+		h.Timeout = 2 * time.Second
+	}
+	if h.HealthyThreshold <= 0 {
+		h.HealthyThreshold = 2
+	}
+	if h.UnhealthyThreshold <= 0 {
+		h.UnhealthyThreshold = 3
+	}
+	if h.ExpectedStatusMin <= 0 {
+		h.ExpectedStatusMin = 200
+	}
+	if h.ExpectedStatusMax <= 0 {
+		h.ExpectedStatusMax = 399
+	}
+	return h
 }
 
 // UpstreamConfig represents an upstream server group
 type UpstreamConfig struct {
-	Name    string   `yaml:"name"`
-	Servers []string `yaml:"servers"`
+	Name        string `yaml:"name"`
+	Policy      string `yaml:"policy"`
+	Servers     []UpstreamServer
+	HealthCheck HealthCheckConfig `yaml:"health_check"`
+}
+
+// rawUpstreamConfig mirrors the YAML shape of UpstreamConfig. Servers stays
+// a plain string list so existing configs (just bare URLs) keep working;
+// each entry may also carry nginx-style attributes, e.g.
+// "http://a:80 weight=5 max_fails=3 fail_timeout=10s".
+type rawUpstreamConfig struct {
+	Name        string            `yaml:"name"`
+	Policy      string            `yaml:"policy"`
+	Servers     []string          `yaml:"servers"`
+	HealthCheck HealthCheckConfig `yaml:"health_check"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler so UpstreamConfig.Servers can be
+// declared as a list of strings in YAML while being stored as structured
+// UpstreamServer values internally.
+func (u *UpstreamConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw rawUpstreamConfig
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+
+	u.Name = raw.Name
+	u.Policy = raw.Policy
+	u.HealthCheck = raw.HealthCheck.WithDefaults()
+	u.Servers = make([]UpstreamServer, 0, len(raw.Servers))
+	for _, entry := range raw.Servers {
+		server, err := parseUpstreamServer(entry)
+		if err != nil {
+			return fmt.Errorf("upstream '%s': %v", raw.Name, err)
+		}
+		u.Servers = append(u.Servers, server)
+	}
+
+	return nil
+}
+
+// parseUpstreamServer parses a single "servers:" entry, e.g.
+// "http://backend1:8080 weight=5 max_fails=2 fail_timeout=30s".
+func parseUpstreamServer(entry string) (UpstreamServer, error) {
+	fields := strings.Fields(entry)
+	if len(fields) == 0 {
+		return UpstreamServer{}, fmt.Errorf("empty server entry")
+	}
+
+	server := UpstreamServer{
+		URL:         fields[0],
+		Weight:      1,
+		MaxFails:    1,
+		FailTimeout: 10 * time.Second,
+	}
+
+	for _, attr := range fields[1:] {
+		key, value, ok := strings.Cut(attr, "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "weight":
+			if w, err := strconv.Atoi(value); err == nil && w > 0 {
+				server.Weight = w
+			}
+		case "max_fails":
+			if mf, err := strconv.Atoi(value); err == nil && mf >= 0 {
+				server.MaxFails = mf
+			}
+		case "fail_timeout":
+			if ft, err := time.ParseDuration(value); err == nil {
+				server.FailTimeout = ft
+			}
+		}
+	}
+
+	return server, nil
+}
+
+// URLs returns the plain backend URLs, in order.
+func (u *UpstreamConfig) URLs() []string {
+	urls := make([]string, len(u.Servers))
+	for i, server := range u.Servers {
+		urls[i] = server.URL
+	}
+	return urls
+}
+
+// AdminConfig configures the separate admin listener that exposes
+// operational endpoints such as /status.
+type AdminConfig struct {
+	Listen string `yaml:"listen"`
 }
 
 // Config represents the main configuration structure
 type Config struct {
 	Servers   []ServerConfig   `yaml:"servers"`
 	Upstreams []UpstreamConfig `yaml:"upstreams"`
+	Admin     AdminConfig      `yaml:"admin"`
 }
 
 // LoadConfig loads configuration from a YAML file
@@ -78,17 +367,34 @@ func (c *Config) ValidateConfig() error {
 			serverNames[server.ServerName] = true
 		}
 
+		if server.TLS.Enabled() && server.ACME.Enabled() {
+			return fmt.Errorf("server %s cannot configure both tls and acme", server.Listen)
+		}
+
+		if server.TLS.Enabled() && (server.TLS.CertFile == "" || server.TLS.KeyFile == "") {
+			return fmt.Errorf("server %s tls block requires both cert_file and key_file", server.Listen)
+		}
+
+		if server.ACME.Enabled() && len(server.ACME.Domains) == 0 {
+			return fmt.Errorf("server %s acme block requires at least one domain", server.Listen)
+		}
+
 		for _, location := range server.Locations {
 			if location.Path == "" {
 				return fmt.Errorf("location path cannot be empty")
 			}
 
-			if location.ProxyPass != "" && location.Root != "" {
-				return fmt.Errorf("location %s cannot have both proxy_pass and root", location.Path)
+			handlerCount := 0
+			for _, set := range []bool{location.ProxyPass != "", location.Root != "", location.FastCGIPass != ""} {
+				if set {
+					handlerCount++
+				}
 			}
-
-			if location.ProxyPass == "" && location.Root == "" {
-				return fmt.Errorf("location %s must have either proxy_pass or root", location.Path)
+			if handlerCount > 1 {
+				return fmt.Errorf("location %s cannot combine proxy_pass, root, and fastcgi_pass", location.Path)
+			}
+			if handlerCount == 0 && location.Return == nil {
+				return fmt.Errorf("location %s must have one of proxy_pass, root, fastcgi_pass, or return", location.Path)
 			}
 
 			if location.ProxyPass != "" {
@@ -110,6 +416,29 @@ func (c *Config) ValidateConfig() error {
 					return fmt.Errorf("root directory does not exist: %s", location.Root)
 				}
 			}
+
+			for _, mw := range location.Middleware {
+				switch mw.Type {
+				case "access_log", "gzip", "rate_limit", "basic_auth", "cors":
+				default:
+					return fmt.Errorf("location %s has unknown middleware type %q", location.Path, mw.Type)
+				}
+			}
+
+			for _, rule := range location.Rewrite {
+				if _, err := regexp.Compile(rule.Pattern); err != nil {
+					return fmt.Errorf("location %s has invalid rewrite pattern %q: %v", location.Path, rule.Pattern, err)
+				}
+				switch rule.Flag {
+				case "", "last", "break", "redirect":
+				default:
+					return fmt.Errorf("location %s has unknown rewrite flag %q", location.Path, rule.Flag)
+				}
+			}
+
+			if location.Return != nil && location.Return.Status == 0 {
+				return fmt.Errorf("location %s return rule requires a status", location.Path)
+			}
 		}
 	}
 
@@ -132,10 +461,22 @@ func (c *Config) PrintConfig() {
 			if location.Root != "" {
 				log.Printf("      root=%s", location.Root)
 			}
+			if location.FastCGIPass != "" {
+				log.Printf("      fastcgi_pass=%s", location.FastCGIPass)
+			}
+			if location.Return != nil {
+				log.Printf("      return=%d", location.Return.Status)
+			}
+			if len(location.Rewrite) > 0 {
+				log.Printf("      rewrite rules=%d", len(location.Rewrite))
+			}
+			if len(location.TryFiles) > 0 {
+				log.Printf("      try_files=%v", location.TryFiles)
+			}
 		}
 	}
 
 	for i, upstream := range c.Upstreams {
-		log.Printf("  Upstream %d: name=%s, servers=%v", i, upstream.Name, upstream.Servers)
+		log.Printf("  Upstream %d: name=%s, policy=%s, servers=%v", i, upstream.Name, upstream.Policy, upstream.URLs())
 	}
 }
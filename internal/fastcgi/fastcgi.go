@@ -0,0 +1,433 @@
+// Package fastcgi implements a minimal FastCGI client sufficient for
+// proxying HTTP requests to a FastCGI application such as PHP-FPM.
+package fastcgi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	fcgiVersion1 = 1
+
+	typeBeginRequest = 1
+	typeAbortRequest = 2
+	typeEndRequest   = 3
+	typeParams       = 4
+	typeStdin        = 5
+	typeStdout       = 6
+	typeStderr       = 7
+
+	roleResponder = 1
+
+	// flagKeepConn tells the application not to close the connection after
+	// finishing this request, so Handle can safely return it to h.pool.
+	flagKeepConn = 1
+
+	maxRecordContent = 65535
+)
+
+// Handler proxies HTTP requests to a FastCGI application.
+type Handler struct {
+	network string
+	address string
+	index   string
+	params  map[string]string
+
+	pool *connPool
+
+	// DialTimeout bounds how long Handle waits for a connection.
+	DialTimeout time.Duration
+	// ReadTimeout bounds how long Handle waits for the application's response.
+	ReadTimeout time.Duration
+}
+
+// NewHandler creates a handler for the given fastcgi_pass target, which is
+// either "host:port" for a TCP upstream or "unix:/path/to.sock" for a Unix
+// domain socket.
+func NewHandler(pass, index string, params map[string]string) (*Handler, error) {
+	network, address, err := parsePass(pass)
+	if err != nil {
+		return nil, err
+	}
+
+	if index == "" {
+		index = "index.php"
+	}
+
+	return &Handler{
+		network:     network,
+		address:     address,
+		index:       index,
+		params:      params,
+		pool:        newConnPool(network, address),
+		DialTimeout: 5 * time.Second,
+		ReadTimeout: 60 * time.Second,
+	}, nil
+}
+
+func parsePass(pass string) (network, address string, err error) {
+	if strings.HasPrefix(pass, "unix:") {
+		return "unix", strings.TrimPrefix(pass, "unix:"), nil
+	}
+	if pass == "" {
+		return "", "", fmt.Errorf("fastcgi_pass cannot be empty")
+	}
+	return "tcp", pass, nil
+}
+
+// Handle handles a single HTTP request by forwarding it to the FastCGI
+// application and writing the application's response back to w.
+func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.pool.get(h.DialTimeout)
+	if err != nil {
+		http.Error(w, "Error contacting FastCGI application", http.StatusBadGateway)
+		return
+	}
+
+	reqID := uint16(1)
+	if err := h.sendRequest(conn, reqID, r); err != nil {
+		conn.Close()
+		http.Error(w, "Error contacting FastCGI application", http.StatusBadGateway)
+		return
+	}
+
+	if h.ReadTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(h.ReadTimeout))
+	}
+
+	if err := h.readResponse(conn, reqID, w); err != nil {
+		conn.Close()
+		return
+	}
+
+	h.pool.put(conn)
+}
+
+func (h *Handler) sendRequest(conn net.Conn, reqID uint16, r *http.Request) error {
+	bw := bufio.NewWriter(conn)
+
+	if err := writeBeginRequest(bw, reqID, roleResponder); err != nil {
+		return err
+	}
+
+	params := h.buildParams(r)
+	if err := writeParamsRecords(bw, reqID, params); err != nil {
+		return err
+	}
+
+	if err := writeStdin(bw, reqID, r.Body); err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// buildParams assembles the CGI-style variables FastCGI applications expect.
+func (h *Handler) buildParams(r *http.Request) map[string]string {
+	scriptName := r.URL.Path
+	documentRoot := h.params["document_root"]
+
+	scriptFilename := h.params["script_filename"]
+	if scriptFilename == "" && documentRoot != "" {
+		scriptFilename = strings.TrimRight(documentRoot, "/") + scriptName
+	}
+
+	contentLength := r.Header.Get("Content-Length")
+	if contentLength == "" && r.ContentLength >= 0 {
+		contentLength = strconv.FormatInt(r.ContentLength, 10)
+	}
+
+	https := ""
+	if r.TLS != nil {
+		https = "on"
+	}
+
+	params := map[string]string{
+		"SCRIPT_FILENAME":   scriptFilename,
+		"SCRIPT_NAME":       scriptName,
+		"PATH_INFO":         r.URL.Path,
+		"QUERY_STRING":      r.URL.RawQuery,
+		"REQUEST_METHOD":    r.Method,
+		"CONTENT_LENGTH":    contentLength,
+		"CONTENT_TYPE":      r.Header.Get("Content-Type"),
+		"REMOTE_ADDR":       remoteAddr(r),
+		"SERVER_PROTOCOL":   r.Proto,
+		"SERVER_SOFTWARE":   "ss-web-server",
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"REQUEST_URI":       r.URL.RequestURI(),
+		"DOCUMENT_ROOT":     documentRoot,
+		"HTTPS":             https,
+	}
+
+	for key, value := range r.Header {
+		if strings.EqualFold(key, "Content-Type") || strings.EqualFold(key, "Content-Length") {
+			continue
+		}
+		name := "HTTP_" + strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+		params[name] = strings.Join(value, ", ")
+	}
+
+	// nginx-style config overrides ($document_root, $fastcgi_script_name, ...)
+	for key, value := range h.params {
+		params[strings.ToUpper(key)] = expandVars(value, scriptName, documentRoot, h.index)
+	}
+
+	return params
+}
+
+func expandVars(value, scriptName, documentRoot, index string) string {
+	value = strings.ReplaceAll(value, "$document_root", documentRoot)
+	value = strings.ReplaceAll(value, "$fastcgi_script_name", scriptName)
+	value = strings.ReplaceAll(value, "$fastcgi_index", index)
+	return value
+}
+
+func remoteAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (h *Handler) readResponse(conn net.Conn, reqID uint16, w http.ResponseWriter) error {
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	br := bufio.NewReader(conn)
+
+	for {
+		rec, err := readRecord(br)
+		if err != nil {
+			return err
+		}
+
+		switch rec.recType {
+		case typeStdout:
+			stdout.Write(rec.content)
+		case typeStderr:
+			stderr.Write(rec.content)
+		case typeEndRequest:
+			return writeCGIResponse(w, &stdout)
+		}
+	}
+}
+
+// writeCGIResponse parses the CGI-style header block (headers, blank line,
+// body) produced by the application and relays it to the client.
+func writeCGIResponse(w http.ResponseWriter, body *bytes.Buffer) error {
+	tp := textproto.NewReader(bufio.NewReader(body))
+	mimeHeader, err := tp.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	status := http.StatusOK
+	if s := mimeHeader.Get("Status"); s != "" {
+		if code, convErr := strconv.Atoi(strings.Fields(s)[0]); convErr == nil {
+			status = code
+		}
+		mimeHeader.Del("Status")
+	}
+
+	for key, values := range mimeHeader {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+
+	w.WriteHeader(status)
+	_, err = io.Copy(w, body)
+	return err
+}
+
+// --- record encoding/decoding ---
+
+type record struct {
+	recType byte
+	reqID   uint16
+	content []byte
+}
+
+func writeBeginRequest(w io.Writer, reqID uint16, role uint16) error {
+	body := make([]byte, 8)
+	binary.BigEndian.PutUint16(body[0:2], role)
+	body[2] = flagKeepConn
+	return writeRecord(w, typeBeginRequest, reqID, body)
+}
+
+func writeParamsRecords(w io.Writer, reqID uint16, params map[string]string) error {
+	var buf bytes.Buffer
+	for key, value := range params {
+		writeNameValuePair(&buf, key, value)
+	}
+
+	if err := writeChunked(w, typeParams, reqID, buf.Bytes()); err != nil {
+		return err
+	}
+	// Empty PARAMS record terminates the stream.
+	return writeRecord(w, typeParams, reqID, nil)
+}
+
+func writeStdin(w io.Writer, reqID uint16, body io.Reader) error {
+	if body != nil {
+		if err := writeChunkedReader(w, typeStdin, reqID, body); err != nil {
+			return err
+		}
+	}
+	// Empty STDIN record terminates the stream.
+	return writeRecord(w, typeStdin, reqID, nil)
+}
+
+func writeChunked(w io.Writer, recType byte, reqID uint16, content []byte) error {
+	for len(content) > 0 {
+		n := len(content)
+		if n > maxRecordContent {
+			n = maxRecordContent
+		}
+		if err := writeRecord(w, recType, reqID, content[:n]); err != nil {
+			return err
+		}
+		content = content[n:]
+	}
+	return nil
+}
+
+func writeChunkedReader(w io.Writer, recType byte, reqID uint16, r io.Reader) error {
+	buf := make([]byte, maxRecordContent)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if werr := writeRecord(w, recType, reqID, buf[:n]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func writeRecord(w io.Writer, recType byte, reqID uint16, content []byte) error {
+	padding := (8 - (len(content) % 8)) % 8
+
+	header := make([]byte, 8)
+	header[0] = fcgiVersion1
+	header[1] = recType
+	binary.BigEndian.PutUint16(header[2:4], reqID)
+	binary.BigEndian.PutUint16(header[4:6], uint16(len(content)))
+	header[6] = byte(padding)
+	header[7] = 0
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if len(content) > 0 {
+		if _, err := w.Write(content); err != nil {
+			return err
+		}
+	}
+	if padding > 0 {
+		if _, err := w.Write(make([]byte, padding)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeNameValuePair(buf *bytes.Buffer, name, value string) {
+	writeLength(buf, len(name))
+	writeLength(buf, len(value))
+	buf.WriteString(name)
+	buf.WriteString(value)
+}
+
+func writeLength(buf *bytes.Buffer, length int) {
+	if length < 128 {
+		buf.WriteByte(byte(length))
+		return
+	}
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(length)|0x80000000)
+	buf.Write(b)
+}
+
+func readRecord(r *bufio.Reader) (*record, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	contentLength := binary.BigEndian.Uint16(header[4:6])
+	paddingLength := header[6]
+
+	content := make([]byte, contentLength)
+	if contentLength > 0 {
+		if _, err := io.ReadFull(r, content); err != nil {
+			return nil, err
+		}
+	}
+	if paddingLength > 0 {
+		if _, err := io.CopyN(io.Discard, r, int64(paddingLength)); err != nil {
+			return nil, err
+		}
+	}
+
+	return &record{
+		recType: header[1],
+		reqID:   binary.BigEndian.Uint16(header[2:4]),
+		content: content,
+	}, nil
+}
+
+// --- connection pooling ---
+
+// connPool keeps a small pool of established connections per FastCGI
+// upstream so repeated requests don't pay the dial cost each time.
+type connPool struct {
+	network string
+	address string
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func newConnPool(network, address string) *connPool {
+	return &connPool{network: network, address: address}
+}
+
+func (p *connPool) get(timeout time.Duration) (net.Conn, error) {
+	p.mu.Lock()
+	if n := len(p.conns); n > 0 {
+		conn := p.conns[n-1]
+		p.conns = p.conns[:n-1]
+		p.mu.Unlock()
+		return conn, nil
+	}
+	p.mu.Unlock()
+
+	return net.DialTimeout(p.network, p.address, timeout)
+}
+
+func (p *connPool) put(conn net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.conns) >= 16 {
+		conn.Close()
+		return
+	}
+	p.conns = append(p.conns, conn)
+}
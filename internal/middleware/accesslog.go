@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+type upstreamContextKey struct{}
+
+// upstreamHolder is placed in the request context by AccessLog before it
+// calls the next handler, and written through by WithUpstream. A plain
+// context.WithValue from inside the handler chain would only be visible to
+// handlers further down the chain, not back up to AccessLog once
+// next.ServeHTTP returns — so the upstream address travels via this shared
+// holder instead of a replaced context value.
+type upstreamHolder struct {
+	value string
+}
+
+// WithUpstream records the upstream address that served r, so AccessLog can
+// report it once the handler chain returns. Handlers that proxy to a
+// backend (e.g. proxy.ProxyHandler) can call this before delegating.
+func WithUpstream(r *http.Request, upstream string) *http.Request {
+	if holder, ok := r.Context().Value(upstreamContextKey{}).(*upstreamHolder); ok {
+		holder.value = upstream
+	}
+	return r
+}
+
+// accessLogEntry is the JSON shape written per request.
+type accessLogEntry struct {
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Status    int    `json:"status"`
+	Bytes     int    `json:"bytes"`
+	Upstream  string `json:"upstream,omitempty"`
+	ClientIP  string `json:"client_ip"`
+	LatencyMS int64  `json:"latency_ms"`
+}
+
+// AccessLog logs one JSON line per request with method, path, status,
+// response size, latency, and (when set) the upstream that served it.
+func AccessLog() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := newStatusRecorder(w)
+
+			holder := &upstreamHolder{}
+			r = r.WithContext(context.WithValue(r.Context(), upstreamContextKey{}, holder))
+
+			next.ServeHTTP(rec, r)
+
+			entry := accessLogEntry{
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				Status:    rec.status,
+				Bytes:     rec.bytes,
+				Upstream:  holder.value,
+				ClientIP:  clientIP(r),
+				LatencyMS: time.Since(start).Milliseconds(),
+			}
+
+			line, err := json.Marshal(entry)
+			if err != nil {
+				log.Printf("access log: failed to marshal entry: %v", err)
+				return
+			}
+			log.Println(string(line))
+		})
+	}
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
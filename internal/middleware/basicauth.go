@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// BasicAuth protects a location with HTTP basic auth, checking credentials
+// against an htpasswd-style file. Supported hash formats are bcrypt
+// ("$2a$", "$2b$", "$2y$", as produced by `htpasswd -B`) and apache's
+// "{SHA}" base64-encoded SHA1 ("htpasswd -s").
+func BasicAuth(htpasswdFile string) (Middleware, error) {
+	credentials, err := loadHtpasswd(htpasswdFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, pass, ok := r.BasicAuth()
+			if !ok || !credentials.verify(user, pass) {
+				w.Header().Set("WWW-Authenticate", `Basic realm="restricted"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+type htpasswdFile struct {
+	hashes map[string]string
+}
+
+func (h *htpasswdFile) verify(user, pass string) bool {
+	hash, ok := h.hashes[user]
+	if !ok {
+		return false
+	}
+
+	if strings.HasPrefix(hash, "{SHA}") {
+		sum := sha1.Sum([]byte(pass))
+		encoded := base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(encoded), []byte(strings.TrimPrefix(hash, "{SHA}"))) == 1
+	}
+
+	if strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$") {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) == nil
+	}
+
+	return false
+}
+
+func loadHtpasswd(path string) (*htpasswdFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open htpasswd_file: %v", err)
+	}
+	defer f.Close()
+
+	hashes := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		hashes[user] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read htpasswd_file: %v", err)
+	}
+
+	return &htpasswdFile{hashes: hashes}, nil
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+)
+
+// CORS applies per-location Access-Control-* headers and short-circuits
+// preflight OPTIONS requests. An empty allowedOrigins or allowedHeaders
+// falls back to a permissive "*"; an empty allowedMethods falls back to
+// "GET, POST, PUT, PATCH, DELETE, OPTIONS".
+func CORS(allowedOrigins, allowedMethods, allowedHeaders []string) Middleware {
+	methods := defaultIfEmpty(allowedMethods, "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+	headers := defaultIfEmpty(allowedHeaders, "*")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin != "" && originAllowed(origin, allowedOrigins) {
+				w.Header().Set("Access-Control-Allow-Origin", corsOriginHeader(origin, allowedOrigins))
+				w.Header().Set("Vary", "Origin")
+			}
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+
+			if r.Method == http.MethodOptions {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func defaultIfEmpty(values []string, fallback string) string {
+	if len(values) == 0 {
+		return fallback
+	}
+	return strings.Join(values, ", ")
+}
+
+func originAllowed(origin string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, a := range allowed {
+		if a == "*" || strings.EqualFold(a, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func corsOriginHeader(origin string, allowed []string) string {
+	if len(allowed) == 0 {
+		return "*"
+	}
+	for _, a := range allowed {
+		if a == "*" {
+			return "*"
+		}
+	}
+	return origin
+}
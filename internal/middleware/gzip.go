@@ -0,0 +1,163 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// defaultGzipMinSize is used when a location doesn't set min_size.
+const defaultGzipMinSize = 1024
+
+// Gzip compresses responses when the client advertises gzip support, the
+// response is at least minSize bytes, and (when mimeAllowlist is non-empty)
+// its Content-Type matches one of mimeAllowlist.
+func Gzip(minSize int, mimeAllowlist []string) Middleware {
+	if minSize <= 0 {
+		minSize = defaultGzipMinSize
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !acceptsGzip(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			gw := &gzipResponseWriter{ResponseWriter: w, minSize: minSize, mimeAllowlist: mimeAllowlist}
+			defer gw.Close()
+
+			next.ServeHTTP(gw, r)
+		})
+	}
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		if strings.TrimSpace(enc) == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter buffers writes until minSize bytes have arrived (or the
+// handler returns) so it can decide, once it knows the response size and
+// Content-Type, whether to compress at all.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+
+	minSize       int
+	mimeAllowlist []string
+
+	status      int
+	buf         []byte
+	gz          *gzip.Writer
+	decided     bool
+	shouldGzip  bool
+	wroteHeader bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	g.status = status
+	g.wroteHeader = true
+}
+
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+	if g.decided {
+		if g.shouldGzip {
+			return g.gz.Write(b)
+		}
+		return g.ResponseWriter.Write(b)
+	}
+
+	g.buf = append(g.buf, b...)
+	if len(g.buf) < g.minSize {
+		// Keep buffering until we can tell whether this response meets the
+		// size threshold, or the handler closes without writing more.
+		return len(b), nil
+	}
+
+	g.decide()
+	return len(b), g.flushBuf()
+}
+
+// decide is called once we have enough bytes (or know we won't get any
+// more) to choose whether to compress.
+func (g *gzipResponseWriter) decide() {
+	if g.decided {
+		return
+	}
+	g.decided = true
+
+	contentType := g.Header().Get("Content-Type")
+	g.shouldGzip = len(g.buf) >= g.minSize && mimeAllowed(contentType, g.mimeAllowlist)
+
+	if g.shouldGzip {
+		g.Header().Set("Content-Encoding", "gzip")
+		g.Header().Del("Content-Length")
+	}
+	g.writeHeader()
+
+	if g.shouldGzip {
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+	}
+}
+
+func (g *gzipResponseWriter) writeHeader() {
+	status := g.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	g.ResponseWriter.WriteHeader(status)
+}
+
+func (g *gzipResponseWriter) flushBuf() error {
+	buf := g.buf
+	g.buf = nil
+	if g.shouldGzip {
+		_, err := g.gz.Write(buf)
+		return err
+	}
+	_, err := g.ResponseWriter.Write(buf)
+	return err
+}
+
+// Close finalizes the response: if nothing ever reached minSize the
+// buffered bytes are flushed uncompressed, otherwise the gzip writer is
+// closed to flush its trailer.
+func (g *gzipResponseWriter) Close() {
+	if !g.decided {
+		g.decide()
+		g.flushBuf()
+	}
+	if g.gz != nil {
+		g.gz.Close()
+	}
+}
+
+// Hijack passes through to the underlying ResponseWriter via HijackFrom.
+// Upgraded connections never go through Write, so there's no buffered/
+// compressed data to reconcile.
+func (g *gzipResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return HijackFrom(g.ResponseWriter)
+}
+
+func mimeAllowed(contentType string, allowlist []string) bool {
+	if len(allowlist) == 0 {
+		return true
+	}
+	base := contentType
+	if idx := strings.Index(base, ";"); idx != -1 {
+		base = base[:idx]
+	}
+	base = strings.TrimSpace(base)
+	for _, allowed := range allowlist {
+		if strings.EqualFold(base, allowed) {
+			return true
+		}
+	}
+	return false
+}
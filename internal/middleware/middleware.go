@@ -0,0 +1,54 @@
+// Package middleware provides composable http.Handler wrappers (access
+// logging, compression, rate limiting, basic auth, CORS) that locations
+// chain in front of their terminal handler.
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"web-server/internal/config"
+)
+
+// Middleware wraps an http.Handler with additional behavior.
+type Middleware func(http.Handler) http.Handler
+
+// Chain composes middlewares so the first entry runs outermost, matching
+// the order a location's middleware: list is declared in.
+func Chain(middlewares ...Middleware) Middleware {
+	return func(next http.Handler) http.Handler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			next = middlewares[i](next)
+		}
+		return next
+	}
+}
+
+// Build turns a location's middleware: list into a single Middleware,
+// preserving declaration order.
+func Build(configs []config.MiddlewareConfig) (Middleware, error) {
+	chain := make([]Middleware, 0, len(configs))
+
+	for _, mw := range configs {
+		switch mw.Type {
+		case "access_log":
+			chain = append(chain, AccessLog())
+		case "gzip":
+			chain = append(chain, Gzip(mw.MinSize, mw.MIMETypes))
+		case "rate_limit":
+			chain = append(chain, RateLimit(mw.Rate, mw.Burst))
+		case "basic_auth":
+			basicAuth, err := BasicAuth(mw.HtpasswdFile)
+			if err != nil {
+				return nil, err
+			}
+			chain = append(chain, basicAuth)
+		case "cors":
+			chain = append(chain, CORS(mw.AllowedOrigins, mw.AllowedMethods, mw.AllowedHeaders))
+		default:
+			return nil, fmt.Errorf("unknown middleware type %q", mw.Type)
+		}
+	}
+
+	return Chain(chain...), nil
+}
@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// maxLimiters bounds the per-location limiter cache so an attacker can't
+// grow it unboundedly by spoofing client IPs.
+const maxLimiters = 4096
+
+// limiterCache is an LRU of token-bucket limiters keyed by client IP.
+type limiterCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	items    map[string]*list.Element
+}
+
+type limiterCacheEntry struct {
+	key     string
+	limiter *rate.Limiter
+}
+
+func newLimiterCache(capacity int) *limiterCache {
+	return &limiterCache{
+		capacity: capacity,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (c *limiterCache) get(key string, r rate.Limit, burst int) *rate.Limiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.order.MoveToFront(el)
+		return el.Value.(*limiterCacheEntry).limiter
+	}
+
+	limiter := rate.NewLimiter(r, burst)
+	el := c.order.PushFront(&limiterCacheEntry{key: key, limiter: limiter})
+	c.items[key] = el
+
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		if oldest != nil {
+			c.order.Remove(oldest)
+			delete(c.items, oldest.Value.(*limiterCacheEntry).key)
+		}
+	}
+
+	return limiter
+}
+
+// RateLimit throttles requests per client IP using a token bucket: rate
+// tokens replenish per second, up to burst tokens banked.
+func RateLimit(requestsPerSecond float64, burst int) Middleware {
+	if burst <= 0 {
+		burst = 1
+	}
+
+	limiters := newLimiterCache(maxLimiters)
+	limit := rate.Limit(requestsPerSecond)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limiter := limiters.get(clientIP(r), limit, burst)
+			if !limiter.Allow() {
+				http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
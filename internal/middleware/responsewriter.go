@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// statusRecorder wraps an http.ResponseWriter to capture the status code
+// and byte count written, for use by access logging and other middleware
+// that need to observe the response after the fact.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+func newStatusRecorder(w http.ResponseWriter) *statusRecorder {
+	return &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+// HijackFrom lets a ResponseWriter wrapper sit in front of a WebSocket
+// upgrade: it passes through to w's Hijacker so proxy.go's http.Hijacker
+// type assertion still succeeds. Embedding an http.ResponseWriter does not
+// promote its Hijack method, so each wrapper type (here, and in package
+// rewrite) defines a one-line Hijack that delegates to this helper.
+func HijackFrom(w http.ResponseWriter) (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("underlying ResponseWriter does not support hijacking")
+	}
+	return hijacker.Hijack()
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.wroteHeader {
+		return
+	}
+	r.wroteHeader = true
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return HijackFrom(r.ResponseWriter)
+}
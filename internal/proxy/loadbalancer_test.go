@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"web-server/internal/config"
+)
+
+func newTestBackends(urls ...string) []*backend {
+	backends := make([]*backend, len(urls))
+	for i, u := range urls {
+		b := &backend{config: config.UpstreamServer{URL: u, Weight: 1}}
+		atomic.StoreInt32(&b.active, 1)
+		backends[i] = b
+	}
+	return backends
+}
+
+func TestRoundRobinBalancerFairness(t *testing.T) {
+	backends := newTestBackends("http://a", "http://b", "http://c")
+	lb := NewLoadBalancer("round_robin")
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	counts := map[string]int{}
+	const trials = 300
+	for i := 0; i < trials; i++ {
+		picked := lb.Select(r, backends)
+		counts[picked.config.URL]++
+	}
+
+	for _, b := range backends {
+		if counts[b.config.URL] != trials/len(backends) {
+			t.Errorf("round_robin: backend %s got %d of %d requests, want exactly %d", b.config.URL, counts[b.config.URL], trials, trials/len(backends))
+		}
+	}
+}
+
+func TestWeightedBalancerDistribution(t *testing.T) {
+	backends := newTestBackends("http://a", "http://b")
+	backends[0].config.Weight = 1
+	backends[1].config.Weight = 3
+
+	lb := NewLoadBalancer("weighted")
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	counts := map[string]int{}
+	const trials = 4000
+	for i := 0; i < trials; i++ {
+		picked := lb.Select(r, backends)
+		counts[picked.config.URL]++
+	}
+
+	// Expect roughly a 1:3 split; allow generous slack since weighted
+	// selection is randomized.
+	got := float64(counts["http://b"]) / float64(counts["http://a"])
+	if got < 2.0 || got > 4.0 {
+		t.Errorf("weighted: got b/a ratio %.2f, want close to 3.0 (counts: %v)", got, counts)
+	}
+}
+
+func TestIPHashBalancerStableForSameClient(t *testing.T) {
+	backends := newTestBackends("http://a", "http://b", "http://c")
+	lb := NewLoadBalancer("ip_hash")
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.5:54321"
+
+	first := lb.Select(r, backends)
+	for i := 0; i < 10; i++ {
+		if picked := lb.Select(r, backends); picked.config.URL != first.config.URL {
+			t.Fatalf("ip_hash: same client mapped to different backends: %s then %s", first.config.URL, picked.config.URL)
+		}
+	}
+}
+
+func TestLeastConnBalancerPrefersFewerInFlight(t *testing.T) {
+	backends := newTestBackends("http://a", "http://b")
+	atomic.StoreInt64(&backends[0].inFlight, 5)
+	atomic.StoreInt64(&backends[1].inFlight, 1)
+
+	lb := NewLoadBalancer("least_conn")
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	picked := lb.Select(r, backends)
+	if picked.config.URL != "http://b" {
+		t.Errorf("least_conn: got %s, want http://b (fewer in-flight)", picked.config.URL)
+	}
+}
+
+func TestSelectUpstreamServerSkipsUnhealthyBackends(t *testing.T) {
+	p := &ProxyHandler{
+		loadBalancer: NewLoadBalancer("round_robin"),
+		backends:     newTestBackends("http://a", "http://b", "http://c"),
+	}
+	// Mark one backend unhealthy mid-flight, as a passive ejection would.
+	atomic.StoreInt32(&p.backends[1].active, 0)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for i := 0; i < 10; i++ {
+		picked := p.selectUpstreamServer(r)
+		if picked.config.URL == "http://b" {
+			t.Fatalf("selectUpstreamServer picked the unhealthy backend http://b")
+		}
+	}
+}
+
+func TestSelectUpstreamServerFailsOpenWhenAllUnhealthy(t *testing.T) {
+	p := &ProxyHandler{
+		loadBalancer: NewLoadBalancer("round_robin"),
+		backends:     newTestBackends("http://a", "http://b"),
+	}
+	for _, b := range p.backends {
+		atomic.StoreInt32(&b.active, 0)
+	}
+
+	picked := p.selectUpstreamServer(httptest.NewRequest(http.MethodGet, "/", nil))
+	if picked == nil {
+		t.Fatal("selectUpstreamServer returned nil when all backends are unhealthy, want fail-open to any backend")
+	}
+}
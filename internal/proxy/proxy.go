@@ -1,6 +1,11 @@
 package proxy
 
 import (
+	"bufio"
+	"crypto/tls"
+	"hash/fnv"
+	"io"
+	"math/rand"
 	"net"
 	"net/http"
 	"net/http/httputil"
@@ -11,46 +16,366 @@ import (
 	"time"
 
 	"web-server/internal/config"
+	"web-server/internal/middleware"
 )
 
+// defaultWebSocketBufferSize is used when a location doesn't set
+// websocket.max_message_size.
+const defaultWebSocketBufferSize = 32 * 1024
+
+// defaultDialTimeout bounds how long we wait to establish the backend
+// connection for a WebSocket upgrade.
+const defaultDialTimeout = 10 * time.Second
+
+// backend tracks the runtime state of a single upstream server: its static
+// config plus the mutable health/load bookkeeping the load balancers and
+// health checker read and update.
+type backend struct {
+	config config.UpstreamServer
+
+	active   int32 // atomic bool, result of the active health checker, 1 = healthy
+	inFlight int64 // atomic count of requests currently being served
+
+	mu                 sync.Mutex
+	consecutiveSuccess int
+	consecutiveFail    int
+	lastCheck          time.Time
+
+	// passive ejection, driven by proxy errors / 5xx responses on the
+	// request path rather than the active checker
+	passiveFails      int
+	passiveWindowFrom time.Time
+	ejectedUntil      time.Time
+}
+
+// isHealthy reports whether the backend should currently receive traffic:
+// the active checker considers it up and it isn't in a passive cool-down.
+func (b *backend) isHealthy() bool {
+	if atomic.LoadInt32(&b.active) == 0 {
+		return false
+	}
+	b.mu.Lock()
+	ejected := time.Now().Before(b.ejectedUntil)
+	b.mu.Unlock()
+	return !ejected
+}
+
+// recordActiveResult applies one active probe outcome using consecutive
+// success/failure thresholds before flipping the backend's active state.
+func (b *backend) recordActiveResult(ok bool, hc config.HealthCheckConfig) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastCheck = time.Now()
+	if ok {
+		b.consecutiveSuccess++
+		b.consecutiveFail = 0
+		if b.consecutiveSuccess >= hc.HealthyThreshold {
+			atomic.StoreInt32(&b.active, 1)
+		}
+	} else {
+		b.consecutiveFail++
+		b.consecutiveSuccess = 0
+		if b.consecutiveFail >= hc.UnhealthyThreshold {
+			atomic.StoreInt32(&b.active, 0)
+		}
+	}
+}
+
+// recordFailure is the passive counterpart: a request through this backend
+// failed (proxy error or 5xx). After MaxFails within FailTimeout the
+// backend is ejected for a FailTimeout cool-down before it can serve again.
+func (b *backend) recordFailure() {
+	maxFails := b.config.MaxFails
+	if maxFails <= 0 {
+		return
+	}
+	failTimeout := b.config.FailTimeout
+	if failTimeout <= 0 {
+		failTimeout = 10 * time.Second
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(b.passiveWindowFrom) > failTimeout {
+		b.passiveFails = 0
+		b.passiveWindowFrom = now
+	}
+	b.passiveFails++
+
+	if b.passiveFails >= maxFails {
+		b.ejectedUntil = now.Add(failTimeout)
+		b.passiveFails = 0
+	}
+}
+
+// recordSuccess clears the passive failure window on a successful response.
+func (b *backend) recordSuccess() {
+	b.mu.Lock()
+	b.passiveFails = 0
+	b.mu.Unlock()
+}
+
+// Stats is a point-in-time snapshot of a backend's health, suitable for
+// exposing on the admin /status endpoint.
+type Stats struct {
+	URL          string    `json:"url"`
+	Healthy      bool      `json:"healthy"`
+	InFlight     int64     `json:"in_flight"`
+	LastCheck    time.Time `json:"last_check"`
+	PassiveFails int       `json:"passive_fails"`
+	EjectedUntil time.Time `json:"ejected_until,omitempty"`
+}
+
+func (b *backend) stats() Stats {
+	// isHealthy takes b.mu itself, so read it before locking.
+	healthy := b.isHealthy()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := Stats{
+		URL:          b.config.URL,
+		Healthy:      healthy,
+		InFlight:     atomic.LoadInt64(&b.inFlight),
+		LastCheck:    b.lastCheck,
+		PassiveFails: b.passiveFails,
+	}
+	if time.Now().Before(b.ejectedUntil) {
+		s.EjectedUntil = b.ejectedUntil
+	}
+	return s
+}
+
+// LoadBalancer picks a backend from the given healthy candidates for a
+// request. Implementations must be safe for concurrent use.
+type LoadBalancer interface {
+	Select(r *http.Request, backends []*backend) *backend
+}
+
+// NewLoadBalancer returns the LoadBalancer for the given UpstreamConfig
+// policy, defaulting to round-robin when the policy is unset or unknown.
+func NewLoadBalancer(policy string) LoadBalancer {
+	switch policy {
+	case "random":
+		return &randomBalancer{}
+	case "least_conn":
+		return &leastConnBalancer{}
+	case "ip_hash":
+		return &ipHashBalancer{}
+	case "weighted":
+		return &weightedBalancer{}
+	default:
+		return &roundRobinBalancer{}
+	}
+}
+
+type roundRobinBalancer struct {
+	counter uint64
+}
+
+func (b *roundRobinBalancer) Select(r *http.Request, backends []*backend) *backend {
+	if len(backends) == 0 {
+		return nil
+	}
+	i := atomic.AddUint64(&b.counter, 1) - 1
+	return backends[i%uint64(len(backends))]
+}
+
+type randomBalancer struct{}
+
+func (b *randomBalancer) Select(r *http.Request, backends []*backend) *backend {
+	if len(backends) == 0 {
+		return nil
+	}
+	return backends[rand.Intn(len(backends))]
+}
+
+// leastConnBalancer picks the backend with the fewest in-flight requests.
+type leastConnBalancer struct{}
+
+func (b *leastConnBalancer) Select(r *http.Request, backends []*backend) *backend {
+	var best *backend
+	var bestInFlight int64
+	for _, candidate := range backends {
+		inFlight := atomic.LoadInt64(&candidate.inFlight)
+		if best == nil || inFlight < bestInFlight {
+			best = candidate
+			bestInFlight = inFlight
+		}
+	}
+	return best
+}
+
+// ipHashBalancer maps a client IP to a fixed backend for session affinity,
+// as long as the set of healthy backends doesn't change.
+type ipHashBalancer struct{}
+
+func (b *ipHashBalancer) Select(r *http.Request, backends []*backend) *backend {
+	if len(backends) == 0 {
+		return nil
+	}
+	h := fnv.New32a()
+	h.Write([]byte(getClientIP(r)))
+	return backends[int(h.Sum32())%len(backends)]
+}
+
+// weightedBalancer distributes requests proportionally to each backend's
+// configured weight using weighted random selection.
+type weightedBalancer struct{}
+
+func (b *weightedBalancer) Select(r *http.Request, backends []*backend) *backend {
+	if len(backends) == 0 {
+		return nil
+	}
+
+	totalWeight := 0
+	for _, candidate := range backends {
+		weight := candidate.config.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		totalWeight += weight
+	}
+
+	target := rand.Intn(totalWeight)
+	for _, candidate := range backends {
+		weight := candidate.config.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		if target < weight {
+			return candidate
+		}
+		target -= weight
+	}
+
+	return backends[len(backends)-1]
+}
+
 // ProxyHandler handles proxying requests to upstream servers
 type ProxyHandler struct {
-	upstreamServers []string
-	currentServer   uint64
-	config          *config.LocationConfig
-	healthStatus    map[string]bool
-	healthMutex     sync.RWMutex
-	lastHealthCheck time.Time
+	name         string
+	backends     []*backend
+	loadBalancer LoadBalancer
+	config       *config.LocationConfig
+	healthCheck  config.HealthCheckConfig
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
 }
 
-// NewProxyHandler creates a new proxy handler
+// NewProxyHandler creates a new proxy handler and starts its active health
+// checker goroutines.
 func NewProxyHandler(upstream *config.UpstreamConfig, config *config.LocationConfig) *ProxyHandler {
 	handler := &ProxyHandler{
-		upstreamServers: upstream.Servers,
-		config:          config,
-		healthStatus:    make(map[string]bool),
+		name:         upstream.Name,
+		config:       config,
+		loadBalancer: NewLoadBalancer(upstream.Policy),
+		healthCheck:  upstream.HealthCheck.WithDefaults(),
+		stopCh:       make(chan struct{}),
 	}
 
 	for _, server := range upstream.Servers {
-		handler.healthStatus[server] = true
+		b := &backend{config: server}
+		atomic.StoreInt32(&b.active, 1)
+		handler.backends = append(handler.backends, b)
 	}
+
+	handler.startHealthChecks()
+
 	return handler
 }
 
+// Stop terminates the handler's active health-check goroutines. Safe to
+// call multiple times.
+func (p *ProxyHandler) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.stopCh)
+	})
+}
+
+// startHealthChecks launches one goroutine per backend that probes
+// HealthCheck.Path on HealthCheck.Interval until Stop is called.
+func (p *ProxyHandler) startHealthChecks() {
+	for _, b := range p.backends {
+		go func(b *backend) {
+			ticker := time.NewTicker(p.healthCheck.Interval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-p.stopCh:
+					return
+				case <-ticker.C:
+					p.probe(b)
+				}
+			}
+		}(b)
+	}
+}
+
+func (p *ProxyHandler) probe(b *backend) {
+	target := strings.TrimRight(b.config.URL, "/") + p.healthCheck.Path
+
+	client := &http.Client{Timeout: p.healthCheck.Timeout}
+	resp, err := client.Get(target)
+	if err != nil {
+		b.recordActiveResult(false, p.healthCheck)
+		return
+	}
+	defer resp.Body.Close()
+
+	ok := resp.StatusCode >= p.healthCheck.ExpectedStatusMin && resp.StatusCode <= p.healthCheck.ExpectedStatusMax
+	b.recordActiveResult(ok, p.healthCheck)
+}
+
+// Stats returns a snapshot of every backend's health, for the admin
+// /status endpoint.
+func (p *ProxyHandler) Stats() []Stats {
+	stats := make([]Stats, 0, len(p.backends))
+	for _, b := range p.backends {
+		stats = append(stats, b.stats())
+	}
+	return stats
+}
+
+// Name returns the upstream name this handler was built from ("direct" for
+// an inline proxy_pass URL), for admin reporting.
+func (p *ProxyHandler) Name() string {
+	return p.name
+}
+
 // Handle handles the proxy request
 func (p *ProxyHandler) Handle(w http.ResponseWriter, r *http.Request) {
-	if len(p.upstreamServers) == 0 {
+	if len(p.backends) == 0 {
+		http.Error(w, "No upstream servers available", http.StatusServiceUnavailable)
+		return
+	}
+
+	target := p.selectUpstreamServer(r)
+	if target == nil {
 		http.Error(w, "No upstream servers available", http.StatusServiceUnavailable)
 		return
 	}
 
-	targetURL := p.selectUpstreamServer()
-	remote, err := url.Parse(targetURL)
+	remote, err := url.Parse(target.config.URL)
 	if err != nil {
 		http.Error(w, "Error parsing upstream server URL", http.StatusInternalServerError)
 		return
 	}
 
+	atomic.AddInt64(&target.inFlight, 1)
+	defer atomic.AddInt64(&target.inFlight, -1)
+
+	r = middleware.WithUpstream(r, target.config.URL)
+
+	if isWebSocketUpgrade(r) {
+		p.handleWebSocket(w, r, target, remote)
+		return
+	}
+
 	proxy := httputil.NewSingleHostReverseProxy(remote)
 
 	originalDirector := proxy.Director
@@ -83,59 +408,223 @@ func (p *ProxyHandler) Handle(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		if resp.StatusCode >= 500 {
+			target.recordFailure()
+		} else {
+			target.recordSuccess()
+		}
+		return nil
+	}
+
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		target.recordFailure()
 		http.Error(w, "Error contacting upstream server", http.StatusBadGateway)
 	}
 
 	proxy.ServeHTTP(w, r)
 }
 
-func (p *ProxyHandler) selectUpstreamServer() string {
-	// Perform health check
-	if time.Since(p.lastHealthCheck) > 30*time.Second {
-		p.performHealthChecks()
-		p.lastHealthCheck = time.Now()
+// isWebSocketUpgrade reports whether r is a WebSocket upgrade request, per
+// RFC 6455: an HTTP/1.1 Connection header containing "upgrade" (it may be a
+// comma-separated list alongside "keep-alive" etc.) and an Upgrade header of
+// "websocket".
+func isWebSocketUpgrade(r *http.Request) bool {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, token := range strings.Split(r.Header.Get("Connection"), ",") {
+		if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+			return true
+		}
+	}
+	return false
+}
+
+// handleWebSocket proxies an Upgrade: websocket request by dialing the
+// backend directly and, once it confirms the 101 Switching Protocols
+// handshake, hijacking the client connection and splicing the two raw
+// connections together. This sidesteps httputil.ReverseProxy's Transport,
+// which strips hop-by-hop headers like Connection/Upgrade before a normal
+// RoundTrip and has no notion of a successful upgrade to hand back to us.
+func (p *ProxyHandler) handleWebSocket(w http.ResponseWriter, r *http.Request, target *backend, remote *url.URL) {
+	backendConn, err := dialUpstream(remote)
+	if err != nil {
+		target.recordFailure()
+		http.Error(w, "Error contacting upstream server", http.StatusBadGateway)
+		return
 	}
 
-	for i := 0; i < len(p.upstreamServers); i++ {
-		server := p.upstreamServers[(p.currentServer+uint64(i))%uint64(len(p.upstreamServers))]
-		p.healthMutex.RLock()
-		isHealthy := p.healthStatus[server]
-		p.healthMutex.RUnlock()
+	outReq := r.Clone(r.Context())
+	outReq.URL.Scheme = remote.Scheme
+	outReq.URL.Host = remote.Host
+	outReq.URL.Path = p.rewritePath(r.URL.Path, remote)
+	outReq.Host = remote.Host
+	outReq.Close = false
+
+	clientIP := getClientIP(r)
+	outReq.Header.Set("X-Forwarded-Host", r.Header.Get("Host"))
+	outReq.Header.Set("X-Forwarded-Proto", getProto(r))
+	outReq.Header.Set("X-Real-IP", clientIP)
+	for header, value := range p.config.ProxySet {
+		substitutedValue := strings.ReplaceAll(value, "$remote_addr", clientIP)
+		substitutedValue = strings.ReplaceAll(substitutedValue, "$host", r.Header.Get("Host"))
+		substitutedValue = strings.ReplaceAll(substitutedValue, "$scheme", getProto(r))
+		outReq.Header.Set(header, substitutedValue)
+	}
 
-		if isHealthy {
-			atomic.AddUint64(&p.currentServer, 1)
-			return server
+	// outReq.Write serializes headers as-is, so Connection/Upgrade/
+	// Sec-WebSocket-* survive untouched rather than being stripped the way
+	// http.Transport.RoundTrip would strip them.
+	if err := outReq.Write(backendConn); err != nil {
+		backendConn.Close()
+		target.recordFailure()
+		http.Error(w, "Error contacting upstream server", http.StatusBadGateway)
+		return
+	}
+
+	backendReader := bufio.NewReader(backendConn)
+	backendResp, err := http.ReadResponse(backendReader, outReq)
+	if err != nil {
+		backendConn.Close()
+		target.recordFailure()
+		http.Error(w, "Error contacting upstream server", http.StatusBadGateway)
+		return
+	}
+
+	if backendResp.StatusCode != http.StatusSwitchingProtocols {
+		// The upstream declined the upgrade; relay its response as-is
+		// without ever hijacking the client connection.
+		defer backendConn.Close()
+		defer backendResp.Body.Close()
+		if backendResp.StatusCode >= 500 {
+			target.recordFailure()
+		} else {
+			target.recordSuccess()
+		}
+		for header, values := range backendResp.Header {
+			for _, value := range values {
+				w.Header().Add(header, value)
+			}
 		}
+		w.WriteHeader(backendResp.StatusCode)
+		io.Copy(w, backendResp.Body)
+		return
 	}
 
-	server := p.upstreamServers[p.currentServer%uint64(len(p.upstreamServers))]
-	atomic.AddUint64(&p.currentServer, 1)
-	return server
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		backendConn.Close()
+		http.Error(w, "WebSocket upgrade not supported", http.StatusInternalServerError)
+		return
+	}
+	clientConn, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		backendConn.Close()
+		http.Error(w, "Error upgrading connection", http.StatusInternalServerError)
+		return
+	}
+
+	if err := backendResp.Write(clientConn); err != nil {
+		clientConn.Close()
+		backendConn.Close()
+		return
+	}
+	target.recordSuccess()
+
+	bufSize := p.config.WebSocket.MaxMessageSize
+	if bufSize <= 0 {
+		bufSize = defaultWebSocketBufferSize
+	}
+
+	client := &hijackedConn{Conn: clientConn, rw: clientBuf}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	// ReadTimeout/WriteTimeout apply only to the hijacked client connection,
+	// per WebSocketConfig's doc: a rolling deadline on each read from and
+	// write to the client, regardless of direction of travel.
+	go copyWebSocket(&wg, backendConn, client, p.config.WebSocket.ReadTimeout, 0, bufSize)
+	go copyWebSocket(&wg, client, backendConn, 0, p.config.WebSocket.WriteTimeout, bufSize)
+	wg.Wait()
+
+	clientConn.Close()
+	backendConn.Close()
+}
+
+// dialUpstream opens a raw connection to remote, establishing TLS if its
+// scheme calls for it.
+func dialUpstream(remote *url.URL) (net.Conn, error) {
+	if remote.Scheme == "https" {
+		host := remote.Hostname()
+		return tls.DialWithDialer(&net.Dialer{Timeout: defaultDialTimeout}, "tcp", remote.Host, &tls.Config{ServerName: host})
+	}
+	return net.DialTimeout("tcp", remote.Host, defaultDialTimeout)
+}
+
+// hijackedConn adapts a hijacked http.Hijacker connection, routing reads and
+// writes through its buffered bufio.ReadWriter so bytes the server already
+// buffered while reading request headers aren't lost.
+type hijackedConn struct {
+	net.Conn
+	rw *bufio.ReadWriter
+}
+
+func (h *hijackedConn) Read(b []byte) (int, error) { return h.rw.Read(b) }
+
+func (h *hijackedConn) Write(b []byte) (int, error) {
+	n, err := h.rw.Write(b)
+	if err == nil {
+		err = h.rw.Flush()
+	}
+	return n, err
 }
 
-func (p *ProxyHandler) performHealthChecks() {
-	for _, server := range p.upstreamServers {
-		go func(srv string) {
-			client := &http.Client{
-				Timeout: 2 * time.Second,
+// copyWebSocket relays from src to dst until either side closes or errors,
+// applying readTimeout as a rolling read deadline on src and writeTimeout
+// as a rolling write deadline on dst, when set. bufSize caps how much is
+// read from src and forwarded in a single pass.
+func copyWebSocket(wg *sync.WaitGroup, dst, src net.Conn, readTimeout, writeTimeout time.Duration, bufSize int) {
+	defer wg.Done()
+
+	buf := make([]byte, bufSize)
+	for {
+		if readTimeout > 0 {
+			src.SetReadDeadline(time.Now().Add(readTimeout))
+		}
+		n, readErr := src.Read(buf)
+		if n > 0 {
+			if writeTimeout > 0 {
+				dst.SetWriteDeadline(time.Now().Add(writeTimeout))
 			}
-			resp, err := client.Get(srv)
-			if err != nil {
-				p.healthMutex.Lock()
-				p.healthStatus[srv] = false
-				p.healthMutex.Unlock()
+			if _, err := dst.Write(buf[:n]); err != nil {
 				return
 			}
-			defer resp.Body.Close()
-			isHealthy := resp.StatusCode >= 200 && resp.StatusCode < 300
-			p.healthMutex.Lock()
-			p.healthStatus[srv] = isHealthy
-			p.healthMutex.Unlock()
-		}(server)
+		}
+		if readErr != nil {
+			return
+		}
 	}
 }
 
+// selectUpstreamServer delegates the pick to the configured LoadBalancer
+// over the currently healthy backends, falling back to any backend if none
+// are currently healthy (fail open rather than reject every request).
+func (p *ProxyHandler) selectUpstreamServer(r *http.Request) *backend {
+	healthy := make([]*backend, 0, len(p.backends))
+	for _, b := range p.backends {
+		if b.isHealthy() {
+			healthy = append(healthy, b)
+		}
+	}
+
+	if len(healthy) == 0 {
+		return p.loadBalancer.Select(r, p.backends)
+	}
+
+	return p.loadBalancer.Select(r, healthy)
+}
+
 // joinURLPath safely joins URL path segments without producing double slashes.
 func joinURLPath(a, b string) string {
 	a = strings.TrimRight(a, "/")
@@ -202,28 +691,25 @@ func getClientIP(r *http.Request) string {
 	return host
 }
 
+// HealthCheck probes every backend synchronously and returns its current
+// reachability, keyed by backend URL. Kept alongside the active health
+// checker for ad-hoc/manual checks (e.g. tooling, CLI diagnostics).
 func (p *ProxyHandler) HealthCheck() map[string]bool {
 	results := make(map[string]bool)
 
-	for _, serverURL := range p.upstreamServers {
-		_, err := url.Parse(serverURL)
-		if err != nil {
-			results[serverURL] = false
-			continue
-		}
-
-		client := &http.Client{
-			Timeout: 5 * time.Second,
-		}
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
 
-		resp, err := client.Get(serverURL)
+	for _, b := range p.backends {
+		resp, err := client.Get(b.config.URL)
 		if err != nil {
-			results[serverURL] = false
+			results[b.config.URL] = false
 			continue
 		}
 		resp.Body.Close()
 
-		results[serverURL] = resp.StatusCode >= 200 && resp.StatusCode < 300
+		results[b.config.URL] = resp.StatusCode >= 200 && resp.StatusCode < 300
 	}
 
 	return results
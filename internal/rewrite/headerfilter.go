@@ -0,0 +1,46 @@
+package rewrite
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+
+	"web-server/internal/middleware"
+)
+
+// headerFilterWriter applies a location's add_header/hide_header rules to
+// whatever the terminal handler writes, right before the status line and
+// headers go out.
+type headerFilterWriter struct {
+	http.ResponseWriter
+
+	add         map[string]string
+	hide        []string
+	wroteHeader bool
+}
+
+func (h *headerFilterWriter) WriteHeader(status int) {
+	if h.wroteHeader {
+		return
+	}
+	h.wroteHeader = true
+
+	for _, name := range h.hide {
+		h.Header().Del(name)
+	}
+	for name, value := range h.add {
+		h.Header().Set(name, value)
+	}
+	h.ResponseWriter.WriteHeader(status)
+}
+
+func (h *headerFilterWriter) Write(b []byte) (int, error) {
+	if !h.wroteHeader {
+		h.WriteHeader(http.StatusOK)
+	}
+	return h.ResponseWriter.Write(b)
+}
+
+func (h *headerFilterWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return middleware.HijackFrom(h.ResponseWriter)
+}
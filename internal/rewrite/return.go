@@ -0,0 +1,20 @@
+package rewrite
+
+import (
+	"net/http"
+
+	"web-server/internal/config"
+)
+
+// serveReturn writes a fixed response for a `return` rule: if Location is
+// set it's sent as a redirect (Body, if any, is still written as the
+// response body), otherwise Status and Body are written as-is.
+func serveReturn(w http.ResponseWriter, rule *config.ReturnRule) {
+	if rule.Location != "" {
+		w.Header().Set("Location", rule.Location)
+	}
+	w.WriteHeader(rule.Status)
+	if rule.Body != "" {
+		w.Write([]byte(rule.Body))
+	}
+}
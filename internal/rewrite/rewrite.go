@@ -0,0 +1,117 @@
+// Package rewrite implements the per-location URL rewrite, redirect, and
+// response-header rules shared by the proxy and static handlers:
+// rewrite/return rules run before the terminal handler, add_header/
+// hide_header wrap its ResponseWriter, and try_files (static locations
+// only) serves the first candidate path that exists on disk.
+package rewrite
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"web-server/internal/config"
+)
+
+// Rule is a compiled config.RewriteRule ready for matching.
+type Rule struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+	Flag        string // "last", "break", or "redirect"
+}
+
+// compile compiles a location's rewrite rules. ValidateConfig already
+// rejects invalid patterns/flags at load time; this is run again per
+// buildServerMux call to get usable *regexp.Regexp values for the handler.
+func compile(rules []config.RewriteRule) ([]Rule, error) {
+	compiled := make([]Rule, 0, len(rules))
+	for _, rule := range rules {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rewrite pattern %q: %v", rule.Pattern, err)
+		}
+		flag := rule.Flag
+		if flag == "" {
+			flag = "last"
+		}
+		compiled = append(compiled, Rule{Pattern: re, Replacement: rule.Replacement, Flag: flag})
+	}
+	return compiled, nil
+}
+
+// apply runs rules in order against path, returning the rewritten path,
+// whether the match carries a "redirect" flag, and whether any rule
+// matched at all. Evaluation stops at the first "redirect" or "break"
+// match; a "last" match keeps applying subsequent rules to the rewritten
+// path, since this server has no further location-matching pass to
+// restart into.
+func apply(rules []Rule, path string) (rewritten string, redirect bool, matched bool) {
+	current := path
+	for _, rule := range rules {
+		if !rule.Pattern.MatchString(current) {
+			continue
+		}
+		current = rule.Pattern.ReplaceAllString(current, rule.Replacement)
+		matched = true
+
+		switch rule.Flag {
+		case "redirect":
+			return current, true, true
+		case "break":
+			return current, false, true
+		}
+	}
+	return current, false, matched
+}
+
+// Wrap builds the rewrite/return/header/try_files handling for location
+// around next, its normal terminal handler (proxy_pass/root/fastcgi_pass).
+// next is called unchanged when none of those directives are set.
+func Wrap(location *config.LocationConfig, next http.Handler) (http.Handler, error) {
+	rules, err := compile(location.Rewrite)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rules) == 0 && location.Return == nil && len(location.AddHeader) == 0 &&
+		len(location.HideHeader) == 0 && len(location.TryFiles) == 0 {
+		return next, nil
+	}
+
+	returnRule := location.Return
+	addHeader := location.AddHeader
+	hideHeader := location.HideHeader
+	tryFiles := location.TryFiles
+	root := location.Root
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if returnRule != nil {
+			serveReturn(w, returnRule)
+			return
+		}
+
+		if len(rules) > 0 {
+			newPath, redirect, matched := apply(rules, r.URL.Path)
+			if matched {
+				if redirect {
+					http.Redirect(w, r, newPath, http.StatusFound)
+					return
+				}
+				r.URL.Path = newPath
+			}
+		}
+
+		if len(tryFiles) > 0 && root != "" {
+			if servedPath, ok := resolveTryFiles(root, r.URL.Path, tryFiles); ok {
+				http.ServeFile(w, r, servedPath)
+				return
+			}
+		}
+
+		if len(addHeader) > 0 || len(hideHeader) > 0 {
+			w = &headerFilterWriter{ResponseWriter: w, add: addHeader, hide: hideHeader}
+		}
+
+		next.ServeHTTP(w, r)
+	}), nil
+}
@@ -0,0 +1,22 @@
+package rewrite
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// resolveTryFiles checks each candidate, with "$uri" substituted for uri,
+// against root in order and returns the first that exists as a regular
+// file.
+func resolveTryFiles(root, uri string, candidates []string) (string, bool) {
+	for _, candidate := range candidates {
+		candidatePath := strings.ReplaceAll(candidate, "$uri", uri)
+		full := filepath.Join(root, candidatePath)
+
+		if info, err := os.Stat(full); err == nil && !info.IsDir() {
+			return full, true
+		}
+	}
+	return "", false
+}
@@ -2,142 +2,529 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 
 	"web-server/internal/config"
+	"web-server/internal/fastcgi"
+	"web-server/internal/middleware"
 	"web-server/internal/proxy"
+	"web-server/internal/rewrite"
 	"web-server/internal/static"
 )
 
+const defaultDrainTimeout = 30 * time.Second
+
+// muxHandler lets a listener's routing table be swapped atomically: in-flight
+// requests keep running against the ServeMux they already loaded, while new
+// requests see whatever reload stored most recently.
+type muxHandler struct {
+	mux atomic.Pointer[http.ServeMux]
+}
+
+func (m *muxHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mux.Load().ServeHTTP(w, r)
+}
+
+// managedServer is one running listener and the state a reload needs to
+// update or tear it down.
+type managedServer struct {
+	listen        string
+	httpServer    *http.Server
+	handler       *muxHandler
+	proxyHandlers []*proxy.ProxyHandler
+	drainTimeout  time.Duration
+}
+
+// builtServer is the result of translating a ServerConfig into a routable
+// mux, independent of whether it backs a new or an existing listener.
+type builtServer struct {
+	mux           *http.ServeMux
+	proxyHandlers []*proxy.ProxyHandler
+}
+
+// companionServer is a small plaintext listener used for ACME HTTP-01
+// challenges and/or redirecting to HTTPS. Its handler can change across
+// reloads (e.g. the set of ACME domains grows), so it's guarded by a mutex
+// rather than recreated.
+type companionServer struct {
+	httpServer *http.Server
+
+	mu      sync.Mutex
+	handler http.Handler
+}
+
+func (c *companionServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	handler := c.handler
+	c.mu.Unlock()
+	handler.ServeHTTP(w, r)
+}
+
+func (c *companionServer) setHandler(handler http.Handler) {
+	c.mu.Lock()
+	c.handler = handler
+	c.mu.Unlock()
+}
+
 // Server represents the main web server
 type Server struct {
-	config  *config.Config
-	servers []*http.Server
+	mu           sync.Mutex
+	config       *config.Config
+	managed      map[string]*managedServer
+	companions   map[string]*companionServer
+	acmeManagers map[string]*acmeEntry
 }
 
 // NewServer creates a new server instance
 func NewServer(config *config.Config) *Server {
 	return &Server{
-		config:  config,
-		servers: make([]*http.Server, 0),
+		config:     config,
+		managed:    make(map[string]*managedServer),
+		companions: make(map[string]*companionServer),
 	}
 }
 
-// Start starts the server
+// Start validates the current configuration, starts a listener per server
+// block, and blocks until SIGINT/SIGTERM triggers a graceful shutdown.
 func (s *Server) Start() error {
 	if err := s.config.ValidateConfig(); err != nil {
 		return fmt.Errorf("configuration validation failed: %v", err)
 	}
 
-	// Print the configuration for debugging
 	s.config.PrintConfig()
 
-	// Create HTTP servers for each server block
-	for _, serverConfig := range s.config.Servers {
-		mux := http.NewServeMux()
-
-		// Sort locations by path length (longest first) to ensure proper matching
-		locations := make([]config.LocationConfig, len(serverConfig.Locations))
-		copy(locations, serverConfig.Locations)
-		sort.Slice(locations, func(i, j int) bool {
-			return len(locations[i].Path) > len(locations[j].Path)
-		})
-
-		// Register handlers for each location
-		for _, location := range locations {
-			var handler http.Handler
-
-			if location.ProxyPass != "" {
-				// Check if proxy_pass refers to an upstream
-				var upstream *config.UpstreamConfig
-				if strings.HasPrefix(location.ProxyPass, "http://") || strings.HasPrefix(location.ProxyPass, "https://") {
-					// Direct proxy - create a single server upstream
-					upstream = &config.UpstreamConfig{
-						Name:    "direct",
-						Servers: []string{location.ProxyPass},
-					}
-				} else {
-					// Upstream reference
-					upstream = s.config.GetUpstreamByName(location.ProxyPass)
-					if upstream == nil {
-						return fmt.Errorf("upstream '%s' not found for location '%s'", location.ProxyPass, location.Path)
-					}
+	s.mu.Lock()
+	err := s.reconcile(s.config)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if s.config.Admin.Listen != "" {
+		s.startAdminServer(s.config.Admin.Listen)
+	}
+
+	// Wait for interrupt signal to gracefully shutdown the server
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	log.Println("Shutting down servers...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultDrainTimeout)
+	defer cancel()
+
+	s.mu.Lock()
+	for _, m := range s.managed {
+		shutdownManaged(ctx, m)
+	}
+	for _, c := range s.companions {
+		c.httpServer.Shutdown(ctx)
+	}
+	s.mu.Unlock()
+
+	log.Println("Server exiting")
+	return nil
+}
+
+// ReloadConfig applies a new configuration without dropping in-flight
+// requests: listeners whose address disappeared are drained and stopped,
+// new listen addresses get a fresh http.Server, and listeners whose address
+// is unchanged have their handler swapped atomically.
+func (s *Server) ReloadConfig(newConfig *config.Config) error {
+	if err := newConfig.ValidateConfig(); err != nil {
+		return fmt.Errorf("new configuration validation failed: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reconcile(newConfig); err != nil {
+		return err
+	}
+
+	s.config = newConfig
+	log.Println("Configuration reloaded")
+	return nil
+}
+
+// preparedServer is the outcome of building and validating one desired
+// server block, before anything about it has touched s.managed. tlsConfig
+// is only set when the block terminates TLS and its listener is new.
+type preparedServer struct {
+	sc           config.ServerConfig
+	built        *builtServer
+	drainTimeout time.Duration
+	isTLS        bool
+	tlsConfig    *tls.Config
+}
+
+// reconcile diffs newConfig.Servers against the currently managed listeners
+// by listen address and brings the managed set in line. Every desired
+// server block is built and validated up front; only once all of them
+// succeed does this mutate s.managed/s.companions, so a failure partway
+// through (e.g. a bad htpasswd_file that ValidateConfig can't see) never
+// leaves some listeners on the new config and others on the old one, and
+// never depends on map iteration order. Callers must hold s.mu.
+func (s *Server) reconcile(newConfig *config.Config) error {
+	desired := make(map[string]config.ServerConfig, len(newConfig.Servers))
+	for _, sc := range newConfig.Servers {
+		desired[sc.Listen] = sc
+	}
+
+	prepared := make(map[string]*preparedServer, len(desired))
+	for addr, sc := range desired {
+		built, err := s.buildServerMux(newConfig, sc)
+		if err != nil {
+			stopPreparedProxyHandlers(prepared)
+			return err
+		}
+
+		drainTimeout := sc.DrainTimeout
+		if drainTimeout <= 0 {
+			drainTimeout = defaultDrainTimeout
+		}
+
+		p := &preparedServer{sc: sc, built: built, drainTimeout: drainTimeout}
+
+		// Only a brand new listener needs its own tls.Config; an existing
+		// listener keeps the one its http.Server was created with.
+		p.isTLS = sc.TLS.Enabled() || sc.ACME.Enabled()
+		if p.isTLS {
+			if _, exists := s.managed[addr]; !exists {
+				tlsConfig, err := buildTLSConfig(sc.TLS)
+				if err != nil {
+					stopPreparedProxyHandlers(prepared)
+					return err
 				}
+				p.tlsConfig = tlsConfig
+			}
+		}
+
+		prepared[addr] = p
+	}
 
-				proxyHandler := proxy.NewProxyHandler(upstream, &location)
-				handler = http.HandlerFunc(proxyHandler.Handle)
-			} else if location.Root != "" {
-				// Static file serving
-				staticHandler := static.NewStaticFileHandler(location.Root, location.Index)
-				handler = http.HandlerFunc(staticHandler.Handle)
+	for addr, m := range s.managed {
+		if _, ok := desired[addr]; !ok {
+			log.Printf("Stopping removed listener %s", addr)
+			stopManaged(m)
+			delete(s.managed, addr)
+		}
+	}
+
+	companionHandlers := make(map[string]http.Handler)
+
+	for addr, p := range prepared {
+		sc := p.sc
+		built := p.built
+
+		if sc.HTTPRedirect || sc.ACME.Enabled() {
+			companionAddr := companionListenAddr(addr)
+			handler := http.Handler(redirectHandler())
+			if sc.ACME.Enabled() {
+				handler = s.acmeManagerFor(sc.ACME).HTTPHandler(handler)
 			}
+			// Only one server block's handler is kept per companion
+			// address; prepared is a map, so which block wins when several
+			// claim the same address is not defined. In practice a single
+			// cache_dir/redirect target is shared.
+			if _, claimed := companionHandlers[companionAddr]; !claimed {
+				companionHandlers[companionAddr] = handler
+			}
+		}
+
+		if existing, ok := s.managed[addr]; ok {
+			// Unchanged listener: swap the handler so in-flight requests
+			// finish against the old routing table while new requests see
+			// the new one, then retire the old proxy handlers' background
+			// health-check goroutines.
+			oldProxyHandlers := existing.proxyHandlers
+			existing.handler.mux.Store(built.mux)
+			existing.proxyHandlers = built.proxyHandlers
+			existing.drainTimeout = p.drainTimeout
+			stopProxyHandlers(oldProxyHandlers)
+			continue
+		}
+
+		handler := &muxHandler{}
+		handler.mux.Store(built.mux)
 
-			// Register the handler for the path
-			mux.Handle(location.Path, handler)
+		var topHandler http.Handler = handler
+		if sc.H2C && !p.isTLS {
+			topHandler = h2c.NewHandler(handler, &http2.Server{})
 		}
 
-		// Create the HTTP server
 		httpServer := &http.Server{
-			Addr:    serverConfig.Listen,
-			Handler: mux,
+			Addr:    addr,
+			Handler: topHandler,
 		}
 
-		s.servers = append(s.servers, httpServer)
+		if p.isTLS {
+			if sc.ACME.Enabled() {
+				p.tlsConfig.GetCertificate = s.acmeManagerFor(sc.ACME).GetCertificate
+			}
+			httpServer.TLSConfig = p.tlsConfig
+			if sc.DisableHTTP2 {
+				// A non-nil, empty map stops net/http from configuring h2
+				// for this server's TLS connections.
+				httpServer.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+			}
+		}
+
+		m := &managedServer{
+			listen:        addr,
+			httpServer:    httpServer,
+			handler:       handler,
+			proxyHandlers: built.proxyHandlers,
+			drainTimeout:  p.drainTimeout,
+		}
+		s.managed[addr] = m
+
+		go func(addr string, httpServer *http.Server, isTLS bool, certFile, keyFile string) {
+			log.Printf("Starting server on %s", addr)
+			var err error
+			if isTLS {
+				err = httpServer.ListenAndServeTLS(certFile, keyFile)
+			} else {
+				err = httpServer.ListenAndServe()
+			}
+			if err != nil && err != http.ErrServerClosed {
+				log.Printf("Server error on %s: %v", addr, err)
+			}
+		}(addr, httpServer, p.isTLS, sc.TLS.CertFile, sc.TLS.KeyFile)
+	}
 
-		// Start the server in a goroutine
-		go func() {
-			log.Printf("Starting server on %s", serverConfig.Listen)
+	s.reconcileCompanions(companionHandlers)
+
+	return nil
+}
+
+// stopPreparedProxyHandlers stops the health-check goroutines of every
+// ProxyHandler built while preparing a reload, used when a later server
+// block in the same reload fails validation and the whole reload is
+// abandoned before any of it reaches s.managed.
+func stopPreparedProxyHandlers(prepared map[string]*preparedServer) {
+	for _, p := range prepared {
+		stopProxyHandlers(p.built.proxyHandlers)
+	}
+}
+
+// reconcileCompanions brings the set of running companion (ACME/redirect)
+// listeners in line with desired, keyed by listen address. Callers must
+// hold s.mu.
+func (s *Server) reconcileCompanions(desired map[string]http.Handler) {
+	for addr, c := range s.companions {
+		if _, ok := desired[addr]; !ok {
+			ctx, cancel := context.WithTimeout(context.Background(), defaultDrainTimeout)
+			c.httpServer.Shutdown(ctx)
+			cancel()
+			delete(s.companions, addr)
+		}
+	}
+
+	for addr, handler := range desired {
+		if existing, ok := s.companions[addr]; ok {
+			existing.setHandler(handler)
+			continue
+		}
+
+		c := &companionServer{handler: handler}
+		httpServer := &http.Server{Addr: addr, Handler: c}
+		c.httpServer = httpServer
+		s.companions[addr] = c
+
+		go func(addr string, httpServer *http.Server) {
+			log.Printf("Starting ACME/redirect companion listener on %s", addr)
 			if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				log.Printf("Server error on %s: %v", serverConfig.Listen, err)
+				log.Printf("Companion listener error on %s: %v", addr, err)
 			}
-		}()
+		}(addr, httpServer)
 	}
+}
 
-	// Wait for interrupt signal to gracefully shutdown the server
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
-	log.Println("Shutting down servers...")
+// companionListenAddr derives the plaintext companion address (port 80) for
+// a TLS listen address, preserving its host.
+func companionListenAddr(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return net.JoinHostPort(host, "80")
+}
 
-	// Create a deadline to wait for
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+// redirectHandler sends every request to the HTTPS equivalent of its URL.
+func redirectHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target := "https://" + r.Host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	}
+}
 
-	// Shutdown all servers
-	for _, server := range s.servers {
-		server.SetKeepAlivesEnabled(false)
-		if err := server.Shutdown(ctx); err != nil {
-			log.Printf("Server forced to shutdown: %v", err)
+// buildServerMux translates a server block into a routable mux plus the
+// proxy handlers it created, so the caller can register it as a new
+// listener's handler or swap it into an existing one.
+func (s *Server) buildServerMux(cfg *config.Config, serverConfig config.ServerConfig) (*builtServer, error) {
+	mux := http.NewServeMux()
+	var proxyHandlers []*proxy.ProxyHandler
+
+	// Sort locations by path length (longest first) to ensure proper matching
+	locations := make([]config.LocationConfig, len(serverConfig.Locations))
+	copy(locations, serverConfig.Locations)
+	sort.Slice(locations, func(i, j int) bool {
+		return len(locations[i].Path) > len(locations[j].Path)
+	})
+
+	// Register handlers for each location
+	for _, location := range locations {
+		var handler http.Handler
+
+		if location.ProxyPass != "" {
+			// Check if proxy_pass refers to an upstream
+			var upstream *config.UpstreamConfig
+			if strings.HasPrefix(location.ProxyPass, "http://") || strings.HasPrefix(location.ProxyPass, "https://") {
+				// Direct proxy - create a single server upstream
+				upstream = &config.UpstreamConfig{
+					Name:        "direct",
+					Servers:     []config.UpstreamServer{{URL: location.ProxyPass, Weight: 1, MaxFails: 1, FailTimeout: 10 * time.Second}},
+					HealthCheck: config.HealthCheckConfig{}.WithDefaults(),
+				}
+			} else {
+				// Upstream reference
+				upstream = cfg.GetUpstreamByName(location.ProxyPass)
+				if upstream == nil {
+					return nil, fmt.Errorf("upstream '%s' not found for location '%s'", location.ProxyPass, location.Path)
+				}
+			}
+
+			proxyHandler := proxy.NewProxyHandler(upstream, &location)
+			proxyHandlers = append(proxyHandlers, proxyHandler)
+			handler = http.HandlerFunc(proxyHandler.Handle)
+		} else if location.Root != "" {
+			// Static file serving
+			staticHandler := static.NewStaticFileHandler(location.Root, location.Index)
+			handler = http.HandlerFunc(staticHandler.Handle)
+		} else if location.FastCGIPass != "" {
+			// FastCGI application (e.g. PHP-FPM)
+			fcgiHandler, err := fastcgi.NewHandler(location.FastCGIPass, location.FastCGIIndex, location.FastCGIParams)
+			if err != nil {
+				return nil, fmt.Errorf("invalid fastcgi_pass for location '%s': %v", location.Path, err)
+			}
+			handler = http.HandlerFunc(fcgiHandler.Handle)
+		}
+
+		if len(location.Middleware) > 0 {
+			chain, err := middleware.Build(location.Middleware)
+			if err != nil {
+				return nil, fmt.Errorf("location %s: %v", location.Path, err)
+			}
+			handler = chain(handler)
+		}
+
+		// rewrite/return/add_header/hide_header/try_files run ahead of
+		// middleware, matching nginx's rewrite phase running before access
+		// control and content phases.
+		handler, err := rewrite.Wrap(&location, handler)
+		if err != nil {
+			return nil, fmt.Errorf("location %s: %v", location.Path, err)
 		}
+
+		// Register the handler for the path
+		mux.Handle(location.Path, handler)
 	}
 
-	log.Println("Server exiting")
-	return nil
+	return &builtServer{mux: mux, proxyHandlers: proxyHandlers}, nil
 }
 
-func (s *Server) ReloadConfig(config *config.Config) error {
-	// Validate new configuration
-	if err := config.ValidateConfig(); err != nil {
-		return fmt.Errorf("new configuration validation failed: %v", err)
+// shutdownManaged gracefully drains a listener that is going away entirely
+// (process shutdown).
+func shutdownManaged(ctx context.Context, m *managedServer) {
+	m.httpServer.SetKeepAlivesEnabled(false)
+	if err := m.httpServer.Shutdown(ctx); err != nil {
+		log.Printf("Server forced to shutdown: %v", err)
+		m.httpServer.Close()
+	}
+	stopProxyHandlers(m.proxyHandlers)
+}
+
+// stopManaged drains a listener that a reload removed, honoring its
+// configured drain timeout rather than the process-wide shutdown one.
+func stopManaged(m *managedServer) {
+	drainTimeout := m.drainTimeout
+	if drainTimeout <= 0 {
+		drainTimeout = defaultDrainTimeout
 	}
 
-	// Update the internal config reference
-	s.config = config
-	log.Println("Configuration reloaded")
-	return nil
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	shutdownManaged(ctx, m)
+}
+
+func stopProxyHandlers(handlers []*proxy.ProxyHandler) {
+	for _, h := range handlers {
+		h.Stop()
+	}
+}
+
+// adminStatus is the JSON payload served at /status: per-upstream backend
+// health as tracked by each location's ProxyHandler.
+type adminStatus struct {
+	Upstreams map[string][]proxy.Stats `json:"upstreams"`
 }
 
+// startAdminServer exposes operational endpoints (currently just /status)
+// on a listener separate from the configured server blocks.
+func (s *Server) startAdminServer(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		status := adminStatus{Upstreams: make(map[string][]proxy.Stats)}
+
+		s.mu.Lock()
+		for _, m := range s.managed {
+			for _, handler := range m.proxyHandlers {
+				status.Upstreams[handler.Name()] = append(status.Upstreams[handler.Name()], handler.Stats()...)
+			}
+		}
+		s.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			log.Printf("Failed to encode admin status: %v", err)
+		}
+	})
+
+	adminServer := &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+
+	go func() {
+		log.Printf("Starting admin server on %s", addr)
+		if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Admin server error on %s: %v", addr, err)
+		}
+	}()
+}
+
+// watchConfigFile watches configPath for changes and calls reloadFunc after
+// a short quiet period, so editors that emit several events per save
+// (write-then-rename, etc.) trigger a single reload instead of several.
 func (s *Server) watchConfigFile(configPath string, reloadFunc func()) {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
@@ -145,24 +532,48 @@ func (s *Server) watchConfigFile(configPath string, reloadFunc func()) {
 	}
 	defer watcher.Close()
 
-	err = watcher.Add(configPath)
-	if err != nil {
+	if err := watcher.Add(configPath); err != nil {
 		log.Fatalf("Failed to watch config file: %v", err)
 	}
 
+	const debounceDelay = 200 * time.Millisecond
+	var debounce *time.Timer
+
 	for {
 		select {
-		case event := <-watcher.Events:
-			if event.Op&fsnotify.Write == fsnotify.Write {
-				log.Println("Config file changed, reloading...")
-				reloadFunc()
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+
+			if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+				// Some editors replace the file atomically on save, which
+				// drops the inode being watched; re-establish the watch.
+				watcher.Remove(configPath)
+				if err := watcher.Add(configPath); err != nil {
+					log.Printf("Failed to re-watch config file: %v", err)
+				}
+			}
+
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) == 0 {
+				continue
+			}
+
+			if debounce != nil {
+				debounce.Stop()
+			}
+			debounce = time.AfterFunc(debounceDelay, reloadFunc)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
 			}
-		case err := <-watcher.Errors:
 			log.Printf("Watcher error: %v", err)
 		}
 	}
 }
 
+// StartWithWatcher starts the server and reloads its configuration whenever
+// configPath changes on disk or the process receives SIGHUP.
 func (s *Server) StartWithWatcher(configPath string) error {
 	var mu sync.Mutex
 	reloadFunc := func() {
@@ -180,5 +591,14 @@ func (s *Server) StartWithWatcher(configPath string) error {
 
 	go s.watchConfigFile(configPath, reloadFunc)
 
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Println("Received SIGHUP, reloading configuration...")
+			reloadFunc()
+		}
+	}()
+
 	return s.Start()
 }
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"web-server/internal/config"
+)
+
+// freeAddr reserves and immediately releases a loopback port so tests can
+// pin a listen address into a config.ServerConfig before the real
+// http.Server binds it.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("reserve port: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+// waitForListener blocks until addr accepts connections or t fails.
+func waitForListener(t *testing.T, addr string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		conn, err := net.DialTimeout("tcp", addr, 50*time.Millisecond)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("listener %s never came up", addr)
+}
+
+func proxyPassConfig(listen, upstreamURL string) *config.Config {
+	return &config.Config{
+		Servers: []config.ServerConfig{
+			{
+				Listen:       listen,
+				DrainTimeout: time.Second,
+				Locations: []config.LocationConfig{
+					{Path: "/", ProxyPass: upstreamURL},
+				},
+			},
+		},
+	}
+}
+
+func stopAllManaged(s *Server) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range s.managed {
+		stopManaged(m)
+	}
+}
+
+// TestReconcileKeepsInFlightRequestOnOldMux verifies the atomic-swap
+// guarantee reconcile relies on: a request that started dispatching before
+// a concurrent reload finishes still runs against the mux it started on,
+// rather than being redirected to the newly-reloaded routing table.
+func TestReconcileKeepsInFlightRequestOnOldMux(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	oldBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "old")
+	}))
+	defer oldBackend.Close()
+
+	newBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "new")
+	}))
+	defer newBackend.Close()
+
+	addr := freeAddr(t)
+	s := NewServer(proxyPassConfig(addr, oldBackend.URL))
+	defer stopAllManaged(s)
+
+	s.mu.Lock()
+	if err := s.reconcile(s.config); err != nil {
+		s.mu.Unlock()
+		t.Fatalf("initial reconcile: %v", err)
+	}
+	s.mu.Unlock()
+	waitForListener(t, addr)
+
+	type result struct {
+		body string
+		err  error
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/")
+		if err != nil {
+			resultCh <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		resultCh <- result{body: string(body), err: err}
+	}()
+
+	<-started // the in-flight request has reached oldBackend and is blocked
+
+	if err := s.ReloadConfig(proxyPassConfig(addr, newBackend.URL)); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+
+	close(release) // let the in-flight request finish against the old backend
+
+	res := <-resultCh
+	if res.err != nil {
+		t.Fatalf("in-flight request failed: %v", res.err)
+	}
+	if res.body != "old" {
+		t.Errorf("in-flight request got body %q, want %q (should keep using the pre-reload mux)", res.body, "old")
+	}
+
+	resp, err := http.Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatalf("post-reload request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "new" {
+		t.Errorf("post-reload request got body %q, want %q", string(body), "new")
+	}
+}
+
+// TestReconcileStopsRemovedListener verifies that a listener whose address
+// is dropped from the config during a reload actually stops accepting
+// connections.
+func TestReconcileStopsRemovedListener(t *testing.T) {
+	keptAddr := freeAddr(t)
+	removedAddr := freeAddr(t)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Servers: []config.ServerConfig{
+			{Listen: keptAddr, DrainTimeout: time.Second, Locations: []config.LocationConfig{{Path: "/", ProxyPass: backend.URL}}},
+			{Listen: removedAddr, DrainTimeout: time.Second, Locations: []config.LocationConfig{{Path: "/", ProxyPass: backend.URL}}},
+		},
+	}
+
+	s := NewServer(cfg)
+	defer stopAllManaged(s)
+
+	s.mu.Lock()
+	if err := s.reconcile(s.config); err != nil {
+		s.mu.Unlock()
+		t.Fatalf("initial reconcile: %v", err)
+	}
+	s.mu.Unlock()
+	waitForListener(t, keptAddr)
+	waitForListener(t, removedAddr)
+
+	reduced := &config.Config{
+		Servers: []config.ServerConfig{
+			{Listen: keptAddr, DrainTimeout: time.Second, Locations: []config.LocationConfig{{Path: "/", ProxyPass: backend.URL}}},
+		},
+	}
+	if err := s.ReloadConfig(reduced); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		conn, err := net.DialTimeout("tcp", removedAddr, 50*time.Millisecond)
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatalf("removed listener %s is still accepting connections after reload", removedAddr)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	resp, err := http.Get("http://" + keptAddr + "/")
+	if err != nil {
+		t.Fatalf("kept listener stopped responding after reload: %v", err)
+	}
+	defer resp.Body.Close()
+}
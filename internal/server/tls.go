@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"web-server/internal/config"
+)
+
+var cipherSuitesByName = map[string]uint16{
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384": tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":   tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305":  tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305":    tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+}
+
+func tlsVersionByName(name string) (uint16, error) {
+	switch name {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	default:
+		return 0, fmt.Errorf("unsupported tls min_version %q", name)
+	}
+}
+
+func clientAuthByName(name string) (tls.ClientAuthType, error) {
+	switch name {
+	case "", "none":
+		return tls.NoClientCert, nil
+	case "request":
+		return tls.RequestClientCert, nil
+	case "require":
+		return tls.RequireAnyClientCert, nil
+	case "verify_if_given":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require_and_verify":
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return 0, fmt.Errorf("unsupported tls client_auth %q", name)
+	}
+}
+
+// buildTLSConfig turns a server block's tls: settings into a *tls.Config.
+// The certificate/key pair itself is loaded by ListenAndServeTLS, which is
+// given the cert/key file paths directly.
+func buildTLSConfig(tlsCfg config.TLSConfig) (*tls.Config, error) {
+	minVersion, err := tlsVersionByName(tlsCfg.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	clientAuth, err := clientAuthByName(tlsCfg.ClientAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		MinVersion: minVersion,
+		ClientAuth: clientAuth,
+	}
+
+	for _, name := range tlsCfg.Ciphers {
+		id, ok := cipherSuitesByName[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported tls cipher %q", name)
+		}
+		cfg.CipherSuites = append(cfg.CipherSuites, id)
+	}
+
+	if tlsCfg.ClientCA != "" {
+		pemBytes, err := os.ReadFile(tlsCfg.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client_ca: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemBytes) {
+			return nil, fmt.Errorf("failed to parse client_ca %s", tlsCfg.ClientCA)
+		}
+		cfg.ClientCAs = pool
+	}
+
+	return cfg, nil
+}
+
+// acmeHosts is an autocert.HostPolicy backed by a mutable set, so a single
+// autocert.Manager can be shared and extended across several server blocks
+// that point at the same cache_dir.
+type acmeHosts struct {
+	mu    sync.Mutex
+	hosts map[string]bool
+}
+
+func newACMEHosts() *acmeHosts {
+	return &acmeHosts{hosts: make(map[string]bool)}
+}
+
+func (h *acmeHosts) add(domains []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, d := range domains {
+		h.hosts[d] = true
+	}
+}
+
+func (h *acmeHosts) policy(_ context.Context, host string) error {
+	h.mu.Lock()
+	ok := h.hosts[host]
+	h.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("acme/autocert: host %q is not configured", host)
+	}
+	return nil
+}
+
+// acmeEntry pairs a shared autocert.Manager with the mutable host set
+// backing its HostPolicy.
+type acmeEntry struct {
+	manager *autocert.Manager
+	hosts   *acmeHosts
+}
+
+// acmeManagerFor returns the autocert.Manager for acmeCfg.CacheDir, creating
+// it on first use. Server blocks that share a cache_dir share both the
+// manager and its on-disk cert cache; acmeCfg.Domains is merged into the
+// manager's allowed host set. Callers must hold s.mu.
+func (s *Server) acmeManagerFor(acmeCfg config.ACMEConfig) *autocert.Manager {
+	if s.acmeManagers == nil {
+		s.acmeManagers = make(map[string]*acmeEntry)
+	}
+
+	entry, ok := s.acmeManagers[acmeCfg.CacheDir]
+	if !ok {
+		hosts := newACMEHosts()
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(acmeCfg.CacheDir),
+			HostPolicy: hosts.policy,
+			Email:      acmeCfg.Email,
+		}
+		if acmeCfg.Staging {
+			manager.Client = &acme.Client{
+				DirectoryURL: "https://acme-staging-v02.api.letsencrypt.org/directory",
+			}
+		}
+		entry = &acmeEntry{manager: manager, hosts: hosts}
+		s.acmeManagers[acmeCfg.CacheDir] = entry
+	}
+
+	entry.hosts.add(acmeCfg.Domains)
+	return entry.manager
+}
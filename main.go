@@ -21,7 +21,7 @@ func main() {
 	}
 
 	srv := server.NewServer(cfg)
-	if err := srv.Start(); err != nil {
+	if err := srv.StartWithWatcher(*configFile); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }